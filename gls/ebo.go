@@ -0,0 +1,103 @@
+// Copyright 2016 The G3N Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gls
+
+import (
+	"github.com/g3n/engine/math32"
+)
+
+// EBO abstracts an OpenGL Element Array Buffer Object (vertex index buffer),
+// used together with a VBO to enable glDrawElements/glDrawElementsInstanced.
+type EBO struct {
+	gs     *GLS            // Reference to OpenGL state
+	handle uint32          // OpenGL handle for this EBO
+	usage  uint32          // Expected usage pattern of the buffer
+	update bool            // Update flag
+	buffer math32.ArrayU32 // Index data buffer
+}
+
+// NewEBO creates and returns a pointer to a new OpenGL Element Array Buffer Object.
+func NewEBO() *EBO {
+
+	ebo := new(EBO)
+	ebo.init()
+	return ebo
+}
+
+// init initializes the EBO.
+func (ebo *EBO) init() {
+
+	ebo.gs = nil
+	ebo.handle = 0
+	ebo.usage = STATIC_DRAW
+	ebo.update = true
+}
+
+// SetBuffer sets the EBO index buffer.
+func (ebo *EBO) SetBuffer(buffer math32.ArrayU32) *EBO {
+
+	ebo.buffer = buffer
+	ebo.update = true
+	return ebo
+}
+
+// Buffer returns a pointer to the EBO index buffer.
+func (ebo *EBO) Buffer() *math32.ArrayU32 {
+
+	return &ebo.buffer
+}
+
+// SetUsage sets the expected usage pattern of the buffer.
+// The default value is GL_STATIC_DRAW.
+func (ebo *EBO) SetUsage(usage uint32) {
+
+	ebo.usage = usage
+}
+
+// Update sets the update flag to force the EBO update.
+func (ebo *EBO) Update() {
+
+	ebo.update = true
+}
+
+// Count returns the number of indices currently in the EBO buffer.
+func (ebo *EBO) Count() int {
+
+	return ebo.buffer.Len()
+}
+
+// Dispose disposes of the OpenGL resources used by the EBO.
+func (ebo *EBO) Dispose() {
+
+	if ebo.gs != nil {
+		ebo.gs.DeleteBuffers(ebo.handle)
+	}
+	ebo.gs = nil
+}
+
+// Transfer (called internally) transfers the index data from the EBO buffer to OpenGL if necessary.
+func (ebo *EBO) Transfer(gs *GLS) {
+
+	// If the EBO buffer is empty, ignore
+	if ebo.buffer.Bytes() == 0 {
+		return
+	}
+
+	// First time initialization
+	if ebo.gs == nil {
+		ebo.handle = gs.GenBuffer()
+		ebo.gs = gs // this indicates that the ebo was initialized
+	}
+
+	// If nothing has changed, no need to transfer data to OpenGL
+	if !ebo.update {
+		return
+	}
+
+	// Transfer the EBO data to OpenGL
+	gs.BindBuffer(ELEMENT_ARRAY_BUFFER, ebo.handle)
+	gs.BufferData(ELEMENT_ARRAY_BUFFER, ebo.buffer.Bytes(), &ebo.buffer[0], ebo.usage)
+	ebo.update = false
+}
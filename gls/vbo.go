@@ -9,20 +9,50 @@ import (
 	"unsafe"
 )
 
+// VBOLayout specifies how the data for the attributes of a VBO is laid
+// out in the underlying OpenGL buffer object(s).
+type VBOLayout int
+
+const (
+	// VBOInterleaved stores all attributes interleaved in a single OpenGL
+	// buffer object. This is the original behavior and the default.
+	VBOInterleaved VBOLayout = iota
+	// VBOSeparate stores each attribute in its own OpenGL buffer object
+	// with its own dirty flag, so attributes that change at different
+	// rates (e.g. static positions vs. per-frame skinning weights) can be
+	// re-uploaded independently instead of forcing a full re-upload of
+	// the whole vertex.
+	VBOSeparate
+)
+
 // VBO abstracts an OpenGL Vertex Buffer Object.
 type VBO struct {
-	gs      *GLS            // Reference to OpenGL state
-	handle  uint32          // OpenGL handle for this VBO
-	usage   uint32          // Expected usage pattern of the buffer
-	update  bool            // Update flag
-	buffer  math32.ArrayF32 // Data buffer
-	attribs []VBOattrib     // List of attributes
+	gs        *GLS             // Reference to OpenGL state
+	handle    uint32           // OpenGL handle for this VBO (VBOInterleaved layout)
+	usage     uint32           // Expected usage pattern of the buffer
+	update    bool             // Update flag (VBOInterleaved layout)
+	buffer    math32.ArrayF32  // Data buffer (VBOInterleaved layout)
+	attribs   []VBOattrib      // List of attributes
+	layout    VBOLayout        // Buffer layout: interleaved (default) or separate
+	streaming VBOStreamingMode // How Transfer/BeginUpdate upload DYNAMIC_DRAW/STREAM_DRAW data
+
+	ringSlotElems int                   // Number of float32 elements per ring slot (VBOPersistent)
+	ringIndex     int                   // Index of the ring slot the next BeginUpdate will hand out
+	mapped        []float32             // Persistently mapped region, ringSlots slots long (VBOPersistent)
+	fences        [vboRingSlots]uintptr // One fence sync object per ring slot, 0 if unset (VBOPersistent)
 }
 
 // VBOattrib describes one attribute of an OpenGL Vertex Buffer Object.
 type VBOattrib struct {
-	Name     string // Name of of the attribute
-	ItemSize int32  // Number of elements
+	Name       string // Name of of the attribute
+	ItemSize   int32  // Number of elements
+	GlType     uint32 // OpenGL base type of each element; FLOAT if added via AddAttrib
+	Normalized bool   // Whether an integer GlType should be normalized to [0,1]/[-1,1]
+	Divisor    uint32 // Instancing divisor: 0 advances per vertex, N>0 advances once every N instances
+
+	handle uint32          // OpenGL buffer handle (VBOSeparate layout only)
+	buffer math32.ArrayF32 // Per-attribute data (VBOSeparate layout only)
+	update bool            // Per-attribute update flag (VBOSeparate layout only)
 }
 
 // NewVBO creates and returns a pointer to a new OpenGL Vertex Buffer Object.
@@ -41,18 +71,51 @@ func (vbo *VBO) init() {
 	vbo.usage = STATIC_DRAW
 	vbo.update = true
 	vbo.attribs = make([]VBOattrib, 0)
+	vbo.layout = VBOInterleaved
+	vbo.streaming = VBOClassic
 }
 
 // AddAttrib adds a new attribute to the VBO.
+// It is equivalent to calling AddAttribTyped(name, itemSize, FLOAT, false).
 func (vbo *VBO) AddAttrib(name string, itemSize int32) *VBO {
 
+	return vbo.AddAttribTyped(name, itemSize, FLOAT, false)
+}
+
+// AddAttribTyped adds a new attribute to the VBO with an explicit OpenGL
+// base type and normalization flag, for compact interleaved vertex
+// formats such as UNSIGNED_BYTE normalized colors, HALF_FLOAT normals/uvs
+// or packed INT_2_10_10_10_REV normals.
+func (vbo *VBO) AddAttribTyped(name string, itemSize int32, glType uint32, normalized bool) *VBO {
+
 	vbo.attribs = append(vbo.attribs, VBOattrib{
-		Name:     name,
-		ItemSize: itemSize,
+		Name:       name,
+		ItemSize:   itemSize,
+		GlType:     glType,
+		Normalized: normalized,
 	})
 	return vbo
 }
 
+// SetDivisor marks the named attribute as instanced with the given
+// divisor: 0 (the default) advances the attribute once per vertex, N>0
+// advances it once every N instances, so Transfer calls
+// VertexAttribDivisor for it. Actually issuing an instanced draw call
+// (glDrawElementsInstanced/glDrawArraysInstanced) is up to the caller;
+// this only wires up the vertex attribute state it depends on. It must
+// be set before the VBO's first Transfer call, as the divisor is applied
+// during buffer setup.
+func (vbo *VBO) SetDivisor(name string, divisor uint32) *VBO {
+
+	for i := range vbo.attribs {
+		if vbo.attribs[i].Name == name {
+			vbo.attribs[i].Divisor = divisor
+			break
+		}
+	}
+	return vbo
+}
+
 // Attrib finds and returns a pointer to the VBO attribute with the specified name.
 // Returns nil if not found.
 func (vbo *VBO) Attrib(name string) *VBOattrib {
@@ -88,12 +151,89 @@ func (vbo *VBO) Attributes() []VBOattrib {
 // it is not referenced counted.
 func (vbo *VBO) Dispose() {
 
-	if vbo.gs != nil {
+	if vbo.gs == nil {
+		return
+	}
+	if vbo.layout == VBOSeparate {
+		for i := range vbo.attribs {
+			if vbo.attribs[i].handle != 0 {
+				vbo.gs.DeleteBuffers(vbo.attribs[i].handle)
+			}
+		}
+	} else {
 		vbo.gs.DeleteBuffers(vbo.handle)
 	}
 	vbo.gs = nil
 }
 
+// SetLayout sets the buffer layout for this VBO. VBOInterleaved (the
+// default) stores all attributes in a single buffer object updated as a
+// whole; VBOSeparate gives each attribute its own buffer object and dirty
+// flag, updated via SetAttribBuffer/UpdateAttrib. Existing geometry that
+// only ever calls SetBuffer/Update keeps working unchanged, since those
+// continue to operate on the VBOInterleaved path.
+// Switching layout frees the buffer object(s) allocated for the old
+// layout, if any, and forces a full re-initialization on the next Transfer.
+func (vbo *VBO) SetLayout(layout VBOLayout) *VBO {
+
+	if vbo.layout != layout {
+		if vbo.gs != nil {
+			if vbo.layout == VBOSeparate {
+				for i := range vbo.attribs {
+					if vbo.attribs[i].handle != 0 {
+						vbo.gs.DeleteBuffers(vbo.attribs[i].handle)
+						vbo.attribs[i].handle = 0
+					}
+				}
+			} else {
+				vbo.gs.DeleteBuffers(vbo.handle)
+				vbo.handle = 0
+			}
+		}
+		vbo.layout = layout
+		vbo.gs = nil
+		vbo.update = true
+		for i := range vbo.attribs {
+			vbo.attribs[i].update = true
+		}
+	}
+	return vbo
+}
+
+// Layout returns the current buffer layout of this VBO.
+func (vbo *VBO) Layout() VBOLayout {
+
+	return vbo.layout
+}
+
+// SetAttribBuffer sets the data buffer of a single attribute and marks it
+// dirty. It is only meaningful for a VBOSeparate VBO; for VBOInterleaved
+// VBOs use SetBuffer with the combined, interleaved data instead.
+func (vbo *VBO) SetAttribBuffer(name string, data math32.ArrayF32) *VBO {
+
+	for i := range vbo.attribs {
+		if vbo.attribs[i].Name == name {
+			vbo.attribs[i].buffer = data
+			vbo.attribs[i].update = true
+			return vbo
+		}
+	}
+	return vbo
+}
+
+// UpdateAttrib marks the named attribute dirty so it is re-uploaded to
+// OpenGL on the next Transfer call. It only has an effect for a
+// VBOSeparate VBO; for VBOInterleaved VBOs use Update instead.
+func (vbo *VBO) UpdateAttrib(name string) {
+
+	for i := range vbo.attribs {
+		if vbo.attribs[i].Name == name {
+			vbo.attribs[i].update = true
+			return
+		}
+	}
+}
+
 // SetBuffer sets the VBO buffer.
 func (vbo *VBO) SetBuffer(buffer math32.ArrayF32) *VBO {
 
@@ -121,8 +261,11 @@ func (vbo *VBO) Update() {
 	vbo.update = true
 }
 
-// AttribOffset returns the total number of elements from
-// all attributes preceding the specified attribute.
+// AttribOffset returns the total number of elements (not bytes) from
+// all attributes preceding the specified attribute. This is only a safe
+// proxy for a byte offset when every attribute is FLOAT; for a VBO with
+// attributes added via AddAttribTyped using a non-FLOAT GlType, use
+// AttribOffsetBytes instead.
 func (vbo *VBO) AttribOffset(name string) int {
 
 	elementCount := 0
@@ -135,6 +278,22 @@ func (vbo *VBO) AttribOffset(name string) int {
 	return elementCount
 }
 
+// AttribOffsetBytes returns the number of bytes occupied by all
+// attributes preceding the specified attribute, correctly accounting for
+// attributes added via AddAttribTyped with a non-FLOAT GlType.
+func (vbo *VBO) AttribOffsetBytes(name string) int32 {
+
+	var offset int32
+	for i := range vbo.attribs {
+		attr := &vbo.attribs[i]
+		if attr.Name == name {
+			return offset
+		}
+		offset += attribBytes(attr)
+	}
+	return offset
+}
+
 // Stride returns the stride of the VBO, which is the number of elements in
 // one complete set of group attributes. E.g. for an interleaved VBO with two attributes:
 // "VertexPosition" (3 elements) and "VertexTexcoord" (2 elements), the stride would be 5:
@@ -153,16 +312,66 @@ func (vbo *VBO) Stride() int {
 // and "VertexTexcoord" (2 elements), the stride would be 5:
 // [X, Y, Z, U, V], X, Y, Z, U, V, X, Y, Z, U, V... X, Y, Z, U, V
 // and the stride size would be: sizeof(float)*stride = 4*5 = 20
+// For attributes added via AddAttribTyped with a non-FLOAT GlType, the
+// attribute's own byte size is used instead of assuming 4 bytes/element.
 func (vbo *VBO) StrideSize() int {
 
-	stride := vbo.Stride()
-	elsize := int(unsafe.Sizeof(float32(0)))
-	return stride * elsize
+	size := 0
+	for i := range vbo.attribs {
+		size += int(attribBytes(&vbo.attribs[i]))
+	}
+	return size
+}
+
+// glTypeSize returns the size in bytes of one component of the given
+// OpenGL attribute type.
+func glTypeSize(glType uint32) int32 {
+
+	switch glType {
+	case BYTE, UNSIGNED_BYTE:
+		return 1
+	case SHORT, UNSIGNED_SHORT, HALF_FLOAT:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// attribBytes returns the number of bytes one vertex's worth of the
+// attribute occupies. Packed types such as INT_2_10_10_10_REV store all
+// ItemSize components in a single 32-bit machine word, rather than
+// ItemSize separate components.
+func attribBytes(attrib *VBOattrib) int32 {
+
+	switch attrib.GlType {
+	case INT_2_10_10_10_REV, UNSIGNED_INT_2_10_10_10_REV:
+		return 4
+	default:
+		return attrib.ItemSize * glTypeSize(attrib.GlType)
+	}
 }
 
-// Transfer (called internally) transfers the data from the VBO buffer to OpenGL if necessary.
+// Transfer (called internally) transfers the data from the VBO buffer(s) to OpenGL if necessary.
 func (vbo *VBO) Transfer(gs *GLS) {
 
+	if vbo.layout == VBOSeparate {
+		vbo.transferSeparate(gs)
+		return
+	}
+	vbo.transferInterleaved(gs)
+}
+
+// transferInterleaved implements Transfer for the (default) VBOInterleaved
+// layout, where all attributes share a single OpenGL buffer object and a
+// single update flag.
+func (vbo *VBO) transferInterleaved(gs *GLS) {
+
+	// The VBOPersistent streaming mode owns its own buffer and upload via
+	// BeginUpdate/EndUpdate; Transfer has nothing to do for it.
+	if vbo.streaming == VBOPersistent {
+		return
+	}
+
 	// If the VBO buffer is empty, ignore
 	if vbo.buffer.Bytes() == 0 {
 		return
@@ -173,22 +382,23 @@ func (vbo *VBO) Transfer(gs *GLS) {
 		vbo.handle = gs.GenBuffer()
 		gs.BindBuffer(ARRAY_BUFFER, vbo.handle)
 		// Calculates stride size
-		strideSize := vbo.StrideSize()
+		strideSize := int32(vbo.StrideSize())
 		// For each attribute
-		var items uint32
-		var offset uint32
-		elsize := int32(unsafe.Sizeof(float32(0)))
-		for _, attrib := range vbo.attribs {
+		var offset int32
+		for i := range vbo.attribs {
+			attrib := &vbo.attribs[i]
 			// Get attribute location in the current program
 			loc := gs.prog.GetAttribLocation(attrib.Name)
 			if loc < 0 {
 				continue
 			}
-			// Enables attribute and sets its stride and offset in the buffer
+			// Enables attribute and sets its type, stride and offset in the buffer
 			gs.EnableVertexAttribArray(uint32(loc))
-			gs.VertexAttribPointer(uint32(loc), attrib.ItemSize, FLOAT, false, int32(strideSize), offset)
-			items += uint32(attrib.ItemSize)
-			offset = uint32(elsize) * items
+			gs.VertexAttribPointer(uint32(loc), attrib.ItemSize, attrib.GlType, attrib.Normalized, strideSize, uint32(offset))
+			if attrib.Divisor > 0 {
+				gs.VertexAttribDivisor(uint32(loc), attrib.Divisor)
+			}
+			offset += attribBytes(attrib)
 		}
 		vbo.gs = gs // this indicates that the vbo was initialized
 	}
@@ -200,6 +410,182 @@ func (vbo *VBO) Transfer(gs *GLS) {
 
 	// Transfer the VBO data to OpenGL
 	gs.BindBuffer(ARRAY_BUFFER, vbo.handle)
-	gs.BufferData(ARRAY_BUFFER, vbo.buffer.Bytes(), &vbo.buffer[0], vbo.usage)
+	if vbo.streaming == VBOOrphan {
+		// Orphan the previous store first so the driver can hand back a
+		// fresh allocation instead of stalling on draws still reading it.
+		gs.BufferData(ARRAY_BUFFER, vbo.buffer.Bytes(), nil, vbo.usage)
+		gs.BufferSubData(ARRAY_BUFFER, 0, vbo.buffer.Bytes(), &vbo.buffer[0])
+	} else {
+		gs.BufferData(ARRAY_BUFFER, vbo.buffer.Bytes(), &vbo.buffer[0], vbo.usage)
+	}
 	vbo.update = false
 }
+
+// transferSeparate implements Transfer for the VBOSeparate layout: each
+// attribute gets its own OpenGL buffer object, tightly packed, and is only
+// re-uploaded when its own update flag is set.
+func (vbo *VBO) transferSeparate(gs *GLS) {
+
+	// First time initialization: one GL buffer per attribute.
+	if vbo.gs == nil {
+		for i := range vbo.attribs {
+			attrib := &vbo.attribs[i]
+			loc := gs.prog.GetAttribLocation(attrib.Name)
+			if loc < 0 {
+				continue
+			}
+			attrib.handle = gs.GenBuffer()
+			gs.BindBuffer(ARRAY_BUFFER, attrib.handle)
+			gs.EnableVertexAttribArray(uint32(loc))
+			gs.VertexAttribPointer(uint32(loc), attrib.ItemSize, attrib.GlType, attrib.Normalized, 0, 0)
+			if attrib.Divisor > 0 {
+				gs.VertexAttribDivisor(uint32(loc), attrib.Divisor)
+			}
+			attrib.update = true
+		}
+		vbo.gs = gs
+	}
+
+	for i := range vbo.attribs {
+		attrib := &vbo.attribs[i]
+		// handle == 0 means the attribute's location wasn't found in the
+		// active program above, so it has no GL buffer to upload to.
+		if !attrib.update || attrib.buffer.Bytes() == 0 || attrib.handle == 0 {
+			continue
+		}
+		gs.BindBuffer(ARRAY_BUFFER, attrib.handle)
+		gs.BufferData(ARRAY_BUFFER, attrib.buffer.Bytes(), &attrib.buffer[0], vbo.usage)
+		attrib.update = false
+	}
+}
+
+// VBOStreamingMode controls how a VBO uploads new data on DYNAMIC_DRAW or
+// STREAM_DRAW usage patterns.
+type VBOStreamingMode int
+
+const (
+	// VBOClassic re-uploads the full buffer through BufferData on every
+	// Transfer call. This is the original behavior, appropriate for
+	// STATIC_DRAW and infrequently updated buffers.
+	VBOClassic VBOStreamingMode = iota
+	// VBOOrphan discards the previous buffer contents with an empty
+	// BufferData call before writing the new contents with BufferSubData,
+	// letting the driver hand back a fresh allocation instead of stalling
+	// on draws that still reference the old one.
+	VBOOrphan
+	// VBOPersistent maps a single buffer, sized for triple-buffering,
+	// once with MapBufferRange(MAP_PERSISTENT_BIT|MAP_COHERENT_BIT) and
+	// cycles through its slots across frames via BeginUpdate/EndUpdate,
+	// using a fence sync object per slot to avoid overwriting data the
+	// GPU has not yet consumed.
+	VBOPersistent
+)
+
+// vboRingSlots is the number of slots the VBOPersistent streaming mode
+// cycles through, one per frame in flight.
+const vboRingSlots = 3
+
+// syncTimeoutNanos bounds how long BeginUpdate waits on a ring slot's
+// fence before giving up; in practice the GPU finishes long before this.
+const syncTimeoutNanos = 1e9
+
+// SetStreamingMode sets how this VBO uploads new data when used with
+// DYNAMIC_DRAW or STREAM_DRAW usage. It has no effect on a VBOSeparate
+// VBO; streaming applies to the single interleaved buffer only.
+func (vbo *VBO) SetStreamingMode(mode VBOStreamingMode) *VBO {
+
+	vbo.streaming = mode
+	return vbo
+}
+
+// StreamingMode returns the current streaming mode of this VBO.
+func (vbo *VBO) StreamingMode() VBOStreamingMode {
+
+	return vbo.streaming
+}
+
+// BeginUpdate maps (lazily allocating on first use, or on growth) this
+// frame's ring slot of the VBOPersistent buffer and returns a slice of
+// "count" float32 elements into it for the caller to write new vertex
+// data directly into GPU-visible memory. It must be paired with a call
+// to EndUpdate once the caller is done writing, and is only valid when
+// the streaming mode is VBOPersistent.
+func (vbo *VBO) BeginUpdate(gs *GLS, count int) []float32 {
+
+	vbo.initPersistent(gs, count)
+
+	// Wait for the GPU to be done reading the slot we are about to overwrite.
+	if vbo.fences[vbo.ringIndex] != 0 {
+		gs.ClientWaitSync(vbo.fences[vbo.ringIndex], SYNC_FLUSH_COMMANDS_BIT, syncTimeoutNanos)
+		gs.DeleteSync(vbo.fences[vbo.ringIndex])
+		vbo.fences[vbo.ringIndex] = 0
+	}
+
+	start := vbo.ringIndex * vbo.ringSlotElems
+	return vbo.mapped[start : start+count]
+}
+
+// EndUpdate must be called after writing into the slice returned by
+// BeginUpdate. It places a fence sync object so the next BeginUpdate on
+// this same ring slot waits for the GPU to finish consuming it, and
+// advances to the next slot.
+func (vbo *VBO) EndUpdate(gs *GLS) {
+
+	vbo.fences[vbo.ringIndex] = gs.FenceSync(SYNC_GPU_COMMANDS_COMPLETE, 0)
+	vbo.ringIndex = (vbo.ringIndex + 1) % vboRingSlots
+}
+
+// initPersistent lazily creates, or grows, the persistently mapped ring
+// buffer backing this VBO when the streaming mode is VBOPersistent.
+// Growing discards and recreates the buffer, so callers that need a
+// stable mapping should size their largest update up front.
+func (vbo *VBO) initPersistent(gs *GLS, elemsPerSlot int) {
+
+	if vbo.gs != nil && elemsPerSlot <= vbo.ringSlotElems {
+		return
+	}
+	if vbo.gs != nil {
+		gs.BindBuffer(ARRAY_BUFFER, vbo.handle)
+		gs.UnmapBuffer(ARRAY_BUFFER)
+		gs.DeleteBuffers(vbo.handle)
+		for i, fence := range vbo.fences {
+			if fence != 0 {
+				gs.DeleteSync(fence)
+				vbo.fences[i] = 0
+			}
+		}
+	}
+
+	vbo.ringSlotElems = elemsPerSlot
+	vbo.ringIndex = 0
+	vbo.fences = [vboRingSlots]uintptr{}
+
+	elsize := int(unsafe.Sizeof(float32(0)))
+	totalBytes := elemsPerSlot * vboRingSlots * elsize
+
+	vbo.handle = gs.GenBuffer()
+	gs.BindBuffer(ARRAY_BUFFER, vbo.handle)
+	mapFlags := uint32(MAP_WRITE_BIT | MAP_PERSISTENT_BIT | MAP_COHERENT_BIT)
+	gs.BufferStorage(ARRAY_BUFFER, totalBytes, nil, mapFlags)
+	ptr := gs.MapBufferRange(ARRAY_BUFFER, 0, totalBytes, mapFlags)
+	vbo.mapped = (*[1 << 30]float32)(ptr)[: elemsPerSlot*vboRingSlots : elemsPerSlot*vboRingSlots]
+
+	// Bind the vertex attribute pointers against this buffer, same
+	// stride/offset computation as transferInterleaved.
+	strideSize := int32(vbo.StrideSize())
+	var offset int32
+	for i := range vbo.attribs {
+		attrib := &vbo.attribs[i]
+		loc := gs.prog.GetAttribLocation(attrib.Name)
+		if loc < 0 {
+			continue
+		}
+		gs.EnableVertexAttribArray(uint32(loc))
+		gs.VertexAttribPointer(uint32(loc), attrib.ItemSize, attrib.GlType, attrib.Normalized, strideSize, uint32(offset))
+		if attrib.Divisor > 0 {
+			gs.VertexAttribDivisor(uint32(loc), attrib.Divisor)
+		}
+		offset += attribBytes(attrib)
+	}
+	vbo.gs = gs
+}
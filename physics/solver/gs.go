@@ -10,6 +10,27 @@ import (
 	"github.com/g3n/engine/math32"
 )
 
+// lambdaCacheMaxAge is the number of frames a cached warm-start lambda is
+// kept after its equation last appeared in the solved set, e.g. because a
+// contact or joint was removed in the meantime.
+const lambdaCacheMaxAge = 4
+
+// lambdaCacheEntry is one entry of GaussSeidel's warm-start cache.
+type lambdaCacheEntry struct {
+	lambda   float32
+	lastSeen uint64
+}
+
+// biasOnlyEquation is implemented by equations that can report their
+// purely positional (Baumgarte) bias separately from the full,
+// restitution-inclusive ComputeB(h). When SplitImpulses is enabled, the
+// position pass uses ComputeBias(h) for equations that implement it, so
+// penetration correction does not leak into the velocity/restitution
+// solution; equations that don't implement it just reuse ComputeB(h).
+type biasOnlyEquation interface {
+	ComputeBias(h float32) float32
+}
+
 // GaussSeidel equation solver.
 // See https://en.wikipedia.org/wiki/Gauss-Seidel_method.
 // The number of solver iterations determines the quality of the solution.
@@ -20,9 +41,34 @@ type GaussSeidel struct {
 	maxIter   int     // Number of solver iterations.
 	tolerance float32 // When the error is less than the tolerance, the system is assumed to be converged.
 
+	warmStartFactor float32 // Scales the previous frame's lambda when seeding the next frame's solve
+	splitImpulses   bool    // Whether to run a separate position/Baumgarte pass
+	frame           uint64
+	lambdaCache     map[uint64]lambdaCacheEntry // Equation.ID() -> previous lambda, for warm starting
+
 	solveInvCs  []float32
 	solveBs     []float32
 	solveLambda []float32
+
+	// Populated only when splitImpulses is true.
+	positionBs     []float32
+	positionLambda []float32
+	// PositionVelocityDeltas/PositionAngularVelocityDeltas hold the
+	// pseudo-velocities produced by the position pass; callers that enable
+	// SplitImpulses should integrate positions with these instead of with
+	// VelocityDeltas/AngularVelocityDeltas, so restitution isn't polluted
+	// by penetration correction.
+	PositionVelocityDeltas        []math32.Vector3
+	PositionAngularVelocityDeltas []math32.Vector3
+}
+
+// PositionDeltas returns the pseudo-velocities produced by the last
+// split-impulse position pass (see SetSplitImpulses). Both slices are
+// empty when split impulses are disabled. IslandSolver uses this method,
+// via a local interface, to merge per-island split-impulse results.
+func (gs *GaussSeidel) PositionDeltas() (velocity, angular []math32.Vector3) {
+
+	return gs.PositionVelocityDeltas, gs.PositionAngularVelocityDeltas
 }
 
 // NewGaussSeidel creates and returns a pointer to a new GaussSeidel constraint equation solver.
@@ -31,6 +77,8 @@ func NewGaussSeidel() *GaussSeidel {
 	gs := new(GaussSeidel)
 	gs.maxIter = 10
 	gs.tolerance = 1e-7
+	gs.warmStartFactor = 0.8
+	gs.lambdaCache = make(map[uint64]lambdaCacheEntry)
 
 	gs.VelocityDeltas = make([]math32.Vector3, 0)
 	gs.AngularVelocityDeltas = make([]math32.Vector3, 0)
@@ -39,9 +87,33 @@ func NewGaussSeidel() *GaussSeidel {
 	gs.solveBs = make([]float32, 0)
 	gs.solveLambda = make([]float32, 0)
 
+	gs.positionBs = make([]float32, 0)
+	gs.positionLambda = make([]float32, 0)
+	gs.PositionVelocityDeltas = make([]math32.Vector3, 0)
+	gs.PositionAngularVelocityDeltas = make([]math32.Vector3, 0)
+
 	return gs
 }
 
+// SetWarmStartFactor sets the fraction of the previous frame's solution
+// used to seed the next frame's solveLambda for the same equation
+// (matched by Equation.ID()). The default is 0.8. Pass 0 to disable warm
+// starting and always begin each solve from rest.
+func (gs *GaussSeidel) SetWarmStartFactor(factor float32) {
+
+	gs.warmStartFactor = factor
+}
+
+// SetSplitImpulses enables or disables split-impulse solving: when
+// enabled, Solve runs a second PGS pass using only the positional
+// (Baumgarte) bias, writing pseudo-velocities into
+// PositionVelocityDeltas/PositionAngularVelocityDeltas instead of mixing
+// penetration correction into VelocityDeltas/AngularVelocityDeltas.
+func (gs *GaussSeidel) SetSplitImpulses(enabled bool) {
+
+	gs.splitImpulses = enabled
+}
+
 func (gs *GaussSeidel) Reset() {
 
 	gs.VelocityDeltas = gs.VelocityDeltas[0:0]
@@ -50,6 +122,11 @@ func (gs *GaussSeidel) Reset() {
 	gs.solveInvCs = gs.solveInvCs[0:0]
 	gs.solveBs = gs.solveBs[0:0]
 	gs.solveLambda = gs.solveLambda[0:0]
+
+	gs.positionBs = gs.positionBs[0:0]
+	gs.positionLambda = gs.positionLambda[0:0]
+	gs.PositionVelocityDeltas = gs.PositionVelocityDeltas[0:0]
+	gs.PositionAngularVelocityDeltas = gs.PositionAngularVelocityDeltas[0:0]
 }
 
 // Solve
@@ -57,90 +134,171 @@ func (gs *GaussSeidel) Solve(frameDelta time.Duration, nBodies int) int {
 
 	gs.Reset()
 
-	iter := 0
 	nEquations := len(gs.equations)
 	h := float32(frameDelta.Seconds())
 
 	// Reset deltas
 	for i := 0; i < nBodies; i++ {
-		gs.VelocityDeltas = append(gs.VelocityDeltas, math32.Vector3{0,0,0})
-		gs.AngularVelocityDeltas = append(gs.AngularVelocityDeltas, math32.Vector3{0,0,0})
+		gs.VelocityDeltas = append(gs.VelocityDeltas, math32.Vector3{0, 0, 0})
+		gs.AngularVelocityDeltas = append(gs.AngularVelocityDeltas, math32.Vector3{0, 0, 0})
+		if gs.splitImpulses {
+			gs.PositionVelocityDeltas = append(gs.PositionVelocityDeltas, math32.Vector3{0, 0, 0})
+			gs.PositionAngularVelocityDeltas = append(gs.PositionAngularVelocityDeltas, math32.Vector3{0, 0, 0})
+		}
 	}
 
-	// Things that do not change during iteration can be computed once
+	// Things that do not change during iteration can be computed once.
+	// Equations also get their solveLambda seeded from the cached,
+	// scaled-down solution of the equation with the same ID last frame.
 	for i := 0; i < nEquations; i++ {
 		eq := gs.equations[i]
-		gs.solveInvCs = append(gs.solveInvCs, 1.0 / eq.ComputeC())
+		gs.solveInvCs = append(gs.solveInvCs, 1.0/eq.ComputeC())
 		gs.solveBs = append(gs.solveBs, eq.ComputeB(h))
-		gs.solveLambda = append(gs.solveLambda, 0.0)
+
+		var lambda0 float32
+		if entry, ok := gs.lambdaCache[eq.ID()]; ok {
+			lambda0 = entry.lambda * gs.warmStartFactor
+		}
+		gs.solveLambda = append(gs.solveLambda, lambda0)
+
+		if gs.splitImpulses {
+			if biasEq, ok := eq.(biasOnlyEquation); ok {
+				gs.positionBs = append(gs.positionBs, biasEq.ComputeBias(h))
+			} else {
+				gs.positionBs = append(gs.positionBs, gs.solveBs[i])
+			}
+			gs.positionLambda = append(gs.positionLambda, 0.0)
+		}
 	}
 
+	iter := 0
 	if nEquations > 0 {
-		tolSquared := gs.tolerance*gs.tolerance
+		// Seed the velocity deltas with the warm-started impulses before
+		// the first iteration, so the sweep below starts from last
+		// frame's (scaled) solution instead of from rest.
+		for j := 0; j < nEquations; j++ {
+			if gs.solveLambda[j] == 0 {
+				continue
+			}
+			gs.applyDelta(j, gs.solveLambda[j], gs.VelocityDeltas, gs.AngularVelocityDeltas)
+		}
+
+		iter = gs.sweep(gs.solveBs, gs.solveLambda, gs.VelocityDeltas, gs.AngularVelocityDeltas)
+		iter += 1
+
+		if gs.splitImpulses {
+			gs.sweep(gs.positionBs, gs.positionLambda, gs.PositionVelocityDeltas, gs.PositionAngularVelocityDeltas)
+		}
 
-		// Iterate over equations
-		for iter = 0; iter < gs.maxIter; iter++ {
+		// Set the .multiplier property of each equation
+		for i := range gs.equations {
+			gs.equations[i].SetMultiplier(gs.solveLambda[i] / h)
+		}
+
+		gs.frame++
+		for i := range gs.equations {
+			gs.lambdaCache[gs.equations[i].ID()] = lambdaCacheEntry{lambda: gs.solveLambda[i], lastSeen: gs.frame}
+		}
+		gs.pruneLambdaCache()
+	}
 
-			// Accumulate the total error for each iteration.
-			deltaLambdaTot := float32(0)
+	return iter
+}
 
-			for j := 0; j < nEquations; j++ {
-				eq := gs.equations[j]
+// sweep runs the Gauss-Seidel iteration against right-hand side bs,
+// writing the resulting per-equation multipliers into lambda and
+// accumulating the resulting per-body deltas into velocityDeltas and
+// angularDeltas. Solve calls it once for the velocity pass and, when
+// SplitImpulses is enabled, a second time for the position pass.
+func (gs *GaussSeidel) sweep(bs, lambda []float32, velocityDeltas, angularDeltas []math32.Vector3) int {
 
-				// Compute iteration
-				lambdaJ := gs.solveLambda[j]
+	nEquations := len(gs.equations)
+	tolSquared := gs.tolerance * gs.tolerance
 
-				idxBodyA := eq.BodyA().Index()
-				idxBodyB := eq.BodyB().Index()
+	iter := 0
+	for ; iter < gs.maxIter; iter++ {
 
-				vA := gs.VelocityDeltas[idxBodyA]
-				vB := gs.VelocityDeltas[idxBodyB]
-				wA := gs.AngularVelocityDeltas[idxBodyA]
-				wB := gs.AngularVelocityDeltas[idxBodyB]
+		// Accumulate the total error for each iteration.
+		deltaLambdaTot := float32(0)
 
-				jeA := eq.JeA()
-				jeB := eq.JeB()
-				spatA := jeA.Spatial()
-				spatB := jeB.Spatial()
-				rotA := jeA.Rotational()
-				rotB := jeB.Rotational()
+		for j := 0; j < nEquations; j++ {
+			eq := gs.equations[j]
 
-				GWlambda := jeA.MultiplyVectors(&vA, &wA) + jeB.MultiplyVectors(&vB, &wB)
+			// Compute iteration
+			lambdaJ := lambda[j]
 
-				deltaLambda := gs.solveInvCs[j] * ( gs.solveBs[j]  - GWlambda - eq.Eps() *lambdaJ)
+			idxBodyA := eq.BodyA().Index()
+			idxBodyB := eq.BodyB().Index()
 
-				// Clamp if we are outside the min/max interval
-				if lambdaJ+deltaLambda < eq.MinForce() {
-					deltaLambda = eq.MinForce() - lambdaJ
-				} else if lambdaJ+deltaLambda > eq.MaxForce() {
-					deltaLambda = eq.MaxForce() - lambdaJ
-				}
-				gs.solveLambda[j] += deltaLambda
-				deltaLambdaTot += math32.Abs(deltaLambda)
+			vA := velocityDeltas[idxBodyA]
+			vB := velocityDeltas[idxBodyB]
+			wA := angularDeltas[idxBodyA]
+			wB := angularDeltas[idxBodyB]
 
-				// Add to velocity deltas
-				gs.VelocityDeltas[idxBodyA].Add(spatA.MultiplyScalar(eq.BodyA().InvMassSolve() * deltaLambda))
-				gs.VelocityDeltas[idxBodyB].Add(spatB.MultiplyScalar(eq.BodyB().InvMassSolve() * deltaLambda))
+			jeA := eq.JeA()
+			jeB := eq.JeB()
+			spatA := jeA.Spatial()
+			spatB := jeB.Spatial()
+			rotA := jeA.Rotational()
+			rotB := jeB.Rotational()
 
-				// Add to angular velocity deltas
-				gs.AngularVelocityDeltas[idxBodyA].Add(rotA.ApplyMatrix3(eq.BodyA().InvInertiaWorldSolve()).MultiplyScalar(deltaLambda))
-				gs.AngularVelocityDeltas[idxBodyB].Add(rotB.ApplyMatrix3(eq.BodyB().InvInertiaWorldSolve()).MultiplyScalar(deltaLambda))
+			GWlambda := jeA.MultiplyVectors(&vA, &wA) + jeB.MultiplyVectors(&vB, &wB)
 
-			}
+			deltaLambda := gs.solveInvCs[j] * (bs[j] - GWlambda - eq.Eps()*lambdaJ)
 
-			// If the total error is small enough - stop iterating
-			if deltaLambdaTot*deltaLambdaTot < tolSquared {
-				break
+			// Clamp if we are outside the min/max interval
+			if lambdaJ+deltaLambda < eq.MinForce() {
+				deltaLambda = eq.MinForce() - lambdaJ
+			} else if lambdaJ+deltaLambda > eq.MaxForce() {
+				deltaLambda = eq.MaxForce() - lambdaJ
 			}
-		}
+			lambda[j] += deltaLambda
+			deltaLambdaTot += math32.Abs(deltaLambda)
 
-		// Set the .multiplier property of each equation
-		for i := range gs.equations {
-			gs.equations[i].SetMultiplier(gs.solveLambda[i] / h)
+			// Add to velocity deltas
+			velocityDeltas[idxBodyA].Add(spatA.MultiplyScalar(eq.BodyA().InvMassSolve() * deltaLambda))
+			velocityDeltas[idxBodyB].Add(spatB.MultiplyScalar(eq.BodyB().InvMassSolve() * deltaLambda))
+
+			// Add to angular velocity deltas
+			angularDeltas[idxBodyA].Add(rotA.ApplyMatrix3(eq.BodyA().InvInertiaWorldSolve()).MultiplyScalar(deltaLambda))
+			angularDeltas[idxBodyB].Add(rotB.ApplyMatrix3(eq.BodyB().InvInertiaWorldSolve()).MultiplyScalar(deltaLambda))
 		}
 
-		iter += 1
+		// If the total error is small enough - stop iterating
+		if deltaLambdaTot*deltaLambdaTot < tolSquared {
+			break
+		}
 	}
 
 	return iter
-}
\ No newline at end of file
+}
+
+// applyDelta scatters equation j's multiplier lambdaJ into the given
+// per-body velocity/angular velocity deltas, the same accumulation sweep
+// does for one equation. Solve uses it to seed the velocity pass with the
+// previous frame's warm-started impulses before iterating.
+func (gs *GaussSeidel) applyDelta(j int, lambdaJ float32, velocityDeltas, angularDeltas []math32.Vector3) {
+
+	eq := gs.equations[j]
+	idxBodyA := eq.BodyA().Index()
+	idxBodyB := eq.BodyB().Index()
+
+	jeA := eq.JeA()
+	jeB := eq.JeB()
+
+	velocityDeltas[idxBodyA].Add(jeA.Spatial().MultiplyScalar(eq.BodyA().InvMassSolve() * lambdaJ))
+	velocityDeltas[idxBodyB].Add(jeB.Spatial().MultiplyScalar(eq.BodyB().InvMassSolve() * lambdaJ))
+	angularDeltas[idxBodyA].Add(jeA.Rotational().ApplyMatrix3(eq.BodyA().InvInertiaWorldSolve()).MultiplyScalar(lambdaJ))
+	angularDeltas[idxBodyB].Add(jeB.Rotational().ApplyMatrix3(eq.BodyB().InvInertiaWorldSolve()).MultiplyScalar(lambdaJ))
+}
+
+// pruneLambdaCache drops cached warm-start lambdas for equations that have
+// not appeared in the solved set for lambdaCacheMaxAge frames.
+func (gs *GaussSeidel) pruneLambdaCache() {
+
+	for id, entry := range gs.lambdaCache {
+		if gs.frame-entry.lastSeen > lambdaCacheMaxAge {
+			delete(gs.lambdaCache, id)
+		}
+	}
+}
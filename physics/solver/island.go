@@ -0,0 +1,308 @@
+// Copyright 2016 The G3N Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package solver
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/g3n/engine/math32"
+)
+
+// island groups the indices, into the shared equations slice, of the
+// equations belonging to one connected component of the body graph.
+type island struct {
+	root    int // union-find root of this island; unique per connected component, unlike minBody
+	minBody int // smallest body index touched by this island, static bodies included; used only for sort order
+	eqIdx   []int
+}
+
+// IslandSolver partitions the current equations into islands (disjoint
+// connected components of the body graph induced by the equations) and
+// solves each island independently, using one Solver instance per island
+// obtained from newSolver. Islands that don't share a body can safely be
+// solved concurrently, so SetParallel(n) lets the islands be spread over
+// up to n goroutines; the per-island results are merged back into a
+// single global VelocityDeltas/AngularVelocityDeltas afterwards.
+//
+// Determinism does not depend on goroutine scheduling: islands are always
+// processed in a stable order (smallest body index first), and within an
+// island the equations keep the order they were added in, so the
+// underlying Solver solves them exactly as it would sequentially.
+type IslandSolver struct {
+	Solver
+	Solution
+	newSolver func() Solver // Creates one Solver instance per island, e.g. func() Solver { return NewGaussSeidel() }
+	parallel  int           // Maximum number of islands solved concurrently; <= 1 means sequential
+
+	uf          []int          // union-find parent array, reused across frames
+	solverCache map[int]Solver // island root -> Solver instance reused across frames, so e.g. GaussSeidel warm-starting still works
+	seenFrame   map[int]uint64 // island root -> last frame it was solved, to evict stale cached solvers
+	frame       uint64
+
+	// PositionVelocityDeltas/PositionAngularVelocityDeltas mirror
+	// GaussSeidel's split-impulse pseudo-velocities (see
+	// GaussSeidel.SetSplitImpulses), merged in from islands whose Solver
+	// implements splitImpulseSolver. They stay empty otherwise.
+	PositionVelocityDeltas        []math32.Vector3
+	PositionAngularVelocityDeltas []math32.Vector3
+}
+
+// splitImpulseSolver is implemented by Solver instances that expose
+// split-impulse pseudo-velocities, such as a GaussSeidel configured with
+// SetSplitImpulses(true). IslandSolver merges these in, in addition to
+// the regular velocity deltas, for islands solved by such a Solver.
+type splitImpulseSolver interface {
+	PositionDeltas() (velocity, angular []math32.Vector3)
+}
+
+// NewIslandSolver creates and returns a pointer to a new IslandSolver.
+// newSolver is called once per island per frame to obtain an independent
+// Solver instance with its own private solving state.
+func NewIslandSolver(newSolver func() Solver) *IslandSolver {
+
+	is := new(IslandSolver)
+	is.newSolver = newSolver
+	is.parallel = 1
+	is.solverCache = make(map[int]Solver)
+	is.seenFrame = make(map[int]uint64)
+
+	is.VelocityDeltas = make([]math32.Vector3, 0)
+	is.AngularVelocityDeltas = make([]math32.Vector3, 0)
+	is.PositionVelocityDeltas = make([]math32.Vector3, 0)
+	is.PositionAngularVelocityDeltas = make([]math32.Vector3, 0)
+
+	return is
+}
+
+// SetParallel sets the maximum number of islands solved concurrently on
+// separate goroutines. n <= 1 solves islands sequentially on the calling
+// goroutine.
+func (is *IslandSolver) SetParallel(n int) {
+
+	is.parallel = n
+}
+
+func (is *IslandSolver) Reset() {
+
+	is.VelocityDeltas = is.VelocityDeltas[0:0]
+	is.AngularVelocityDeltas = is.AngularVelocityDeltas[0:0]
+	is.PositionVelocityDeltas = is.PositionVelocityDeltas[0:0]
+	is.PositionAngularVelocityDeltas = is.PositionAngularVelocityDeltas[0:0]
+}
+
+// Solve partitions is.equations into islands, solves each island with its
+// own Solver instance (in parallel when SetParallel allows it), and
+// merges the resulting per-body deltas back into is.VelocityDeltas and
+// is.AngularVelocityDeltas. It returns the largest per-island iteration
+// count, matching the meaning of a single Solver's Solve return value.
+func (is *IslandSolver) Solve(frameDelta time.Duration, nBodies int) int {
+
+	is.Reset()
+	for i := 0; i < nBodies; i++ {
+		is.VelocityDeltas = append(is.VelocityDeltas, math32.Vector3{0, 0, 0})
+		is.AngularVelocityDeltas = append(is.AngularVelocityDeltas, math32.Vector3{0, 0, 0})
+		is.PositionVelocityDeltas = append(is.PositionVelocityDeltas, math32.Vector3{0, 0, 0})
+		is.PositionAngularVelocityDeltas = append(is.PositionAngularVelocityDeltas, math32.Vector3{0, 0, 0})
+	}
+
+	nEquations := len(is.equations)
+	if nEquations == 0 {
+		return 0
+	}
+
+	is.frame++
+	islands := is.partition(nBodies)
+
+	limit := is.parallel
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	maxIter := 0
+
+	for _, isl := range islands {
+		isl := isl
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Reuse the solver keyed by this island's union-find root
+			// across frames (islands are usually stable frame to frame)
+			// so per-equation state such as GaussSeidel's warm-start
+			// lambda cache keeps working under IslandSolver too. The root
+			// is unique per connected component; minBody is not, since a
+			// shared static body (e.g. a ground plane) is included when
+			// computing it but excluded from the union-find, so two
+			// distinct islands touching the same static body can and do
+			// compute the same minBody.
+			mu.Lock()
+			solver, ok := is.solverCache[isl.root]
+			if !ok {
+				solver = is.newSolver()
+				is.solverCache[isl.root] = solver
+			}
+			is.seenFrame[isl.root] = is.frame
+			mu.Unlock()
+
+			// A reused solver carries over last frame's equations, so
+			// drop them before adding this frame's island membership.
+			solver.ClearEquations()
+			for _, idx := range isl.eqIdx {
+				solver.AddEquation(is.equations[idx])
+			}
+			// Solved with the global nBodies, not just this island's
+			// bodies: equations reference global body indices, and
+			// remapping them to a per-island local range isn't worth the
+			// complexity unless islands are numerous and tiny. For scenes
+			// with many small islands this means each one still allocates
+			// O(nBodies) scratch slices.
+			iter := solver.Solve(frameDelta, nBodies)
+			vd := solver.GetVelocityDeltas()
+			avd := solver.GetAngularVelocityDeltas()
+
+			var pvd, pavd []math32.Vector3
+			if splitSolver, ok := solver.(splitImpulseSolver); ok {
+				pvd, pavd = splitSolver.PositionDeltas()
+			}
+
+			// Bodies with zero inverse mass (static/kinematic) are never
+			// exclusively owned by one island: two different islands can
+			// both reference the same static body (e.g. a shared ground
+			// plane), so writing its index here races with another
+			// goroutine doing the same. Their delta is always zero, so
+			// skip them instead of merging.
+			for _, idx := range isl.eqIdx {
+				eq := is.equations[idx]
+				a := eq.BodyA().Index()
+				b := eq.BodyB().Index()
+				if eq.BodyA().InvMassSolve() != 0 {
+					is.VelocityDeltas[a] = vd[a]
+					is.AngularVelocityDeltas[a] = avd[a]
+					if len(pvd) != 0 {
+						is.PositionVelocityDeltas[a] = pvd[a]
+						is.PositionAngularVelocityDeltas[a] = pavd[a]
+					}
+				}
+				if eq.BodyB().InvMassSolve() != 0 {
+					is.VelocityDeltas[b] = vd[b]
+					is.AngularVelocityDeltas[b] = avd[b]
+					if len(pvd) != 0 {
+						is.PositionVelocityDeltas[b] = pvd[b]
+						is.PositionAngularVelocityDeltas[b] = pavd[b]
+					}
+				}
+			}
+
+			mu.Lock()
+			if iter > maxIter {
+				maxIter = iter
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	is.pruneSolverCache()
+
+	return maxIter
+}
+
+// pruneSolverCache drops cached per-island solvers whose union-find root
+// has not appeared in the solved set for lambdaCacheMaxAge frames, e.g.
+// because its island was merged into, or split off from, another.
+func (is *IslandSolver) pruneSolverCache() {
+
+	for root, lastSeen := range is.seenFrame {
+		if is.frame-lastSeen > lambdaCacheMaxAge {
+			delete(is.seenFrame, root)
+			delete(is.solverCache, root)
+		}
+	}
+}
+
+// partition builds a union-find over the nBodies body indices connected
+// by is.equations, groups the equations by the resulting connected
+// component, and returns the islands sorted by smallest body index first.
+func (is *IslandSolver) partition(nBodies int) []*island {
+
+	if cap(is.uf) < nBodies {
+		is.uf = make([]int, nBodies)
+	}
+	is.uf = is.uf[:nBodies]
+	for i := range is.uf {
+		is.uf[i] = i
+	}
+
+	var find func(int) int
+	find = func(x int) int {
+		for is.uf[x] != x {
+			is.uf[x] = is.uf[is.uf[x]]
+			x = is.uf[x]
+		}
+		return x
+	}
+	union := func(a, b int) {
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			is.uf[ra] = rb
+		}
+	}
+
+	// Bodies with zero inverse mass are static/kinematic. They must not
+	// propagate connectivity between islands, or every island touching a
+	// shared static body (e.g. a ground plane under many separate stacks)
+	// would collapse into one, the same special case Bullet/ODE's island
+	// builders carve out.
+	for _, eq := range is.equations {
+		aStatic := eq.BodyA().InvMassSolve() == 0
+		bStatic := eq.BodyB().InvMassSolve() == 0
+		if !aStatic && !bStatic {
+			union(eq.BodyA().Index(), eq.BodyB().Index())
+		}
+	}
+
+	islandsByRoot := make(map[int]*island)
+	for i, eq := range is.equations {
+		a, b := eq.BodyA().Index(), eq.BodyB().Index()
+		aStatic := eq.BodyA().InvMassSolve() == 0
+		bStatic := eq.BodyB().InvMassSolve() == 0
+
+		// Key the island by whichever body is dynamic; if both are
+		// static/kinematic (a degenerate equation) fall back to bodyA.
+		root := find(a)
+		if aStatic && !bStatic {
+			root = find(b)
+		}
+
+		isl, ok := islandsByRoot[root]
+		if !ok {
+			isl = &island{root: root, minBody: a}
+			islandsByRoot[root] = isl
+		}
+		if a < isl.minBody {
+			isl.minBody = a
+		}
+		if b < isl.minBody {
+			isl.minBody = b
+		}
+		isl.eqIdx = append(isl.eqIdx, i)
+	}
+
+	islands := make([]*island, 0, len(islandsByRoot))
+	for _, isl := range islandsByRoot {
+		islands = append(islands, isl)
+	}
+	sort.Slice(islands, func(i, j int) bool {
+		return islands[i].minBody < islands[j].minBody
+	})
+
+	return islands
+}
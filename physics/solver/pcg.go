@@ -0,0 +1,223 @@
+// Copyright 2016 The G3N Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package solver
+
+import (
+	"time"
+
+	"github.com/g3n/engine/math32"
+)
+
+// PCG is a Preconditioned Conjugate Gradient constraint equation solver.
+// See https://en.wikipedia.org/wiki/Conjugate_gradient_method.
+// Unlike GaussSeidel, it solves the full symmetric system A*lambda = b
+// each frame rather than relaxing it row by row, which converges in far
+// fewer iterations for stiff systems such as large stacks or long
+// kinematic chains, where GaussSeidel can require hundreds of iterations.
+type PCG struct {
+	Solver
+	Solution
+	maxIter   int     // Number of solver iterations.
+	tolerance float32 // When the squared residual norm is less than this, the system is assumed to be converged.
+
+	scratchV []math32.Vector3 // Per-body velocity delta scratch space, written by matVec
+	scratchW []math32.Vector3 // Per-body angular velocity delta scratch space, written by matVec
+
+	solveInvCs []float32 // Jacobi preconditioner: 1/ComputeC() per equation
+	lambda     []float32 // Current solution per equation
+	r          []float32 // Residual b - A*lambda
+	z          []float32 // Preconditioned residual M^-1 * r
+	p          []float32 // Search direction
+	ap         []float32 // A*p, recomputed by matVec every iteration
+}
+
+// NewPCG creates and returns a pointer to a new PCG constraint equation solver.
+func NewPCG() *PCG {
+
+	cg := new(PCG)
+	cg.maxIter = 10
+	cg.tolerance = 1e-7
+
+	cg.VelocityDeltas = make([]math32.Vector3, 0)
+	cg.AngularVelocityDeltas = make([]math32.Vector3, 0)
+
+	cg.scratchV = make([]math32.Vector3, 0)
+	cg.scratchW = make([]math32.Vector3, 0)
+
+	cg.solveInvCs = make([]float32, 0)
+	cg.lambda = make([]float32, 0)
+	cg.r = make([]float32, 0)
+	cg.z = make([]float32, 0)
+	cg.p = make([]float32, 0)
+	cg.ap = make([]float32, 0)
+
+	return cg
+}
+
+func (cg *PCG) Reset() {
+
+	cg.VelocityDeltas = cg.VelocityDeltas[0:0]
+	cg.AngularVelocityDeltas = cg.AngularVelocityDeltas[0:0]
+	cg.scratchV = cg.scratchV[0:0]
+	cg.scratchW = cg.scratchW[0:0]
+
+	cg.solveInvCs = cg.solveInvCs[0:0]
+	cg.lambda = cg.lambda[0:0]
+	cg.r = cg.r[0:0]
+	cg.z = cg.z[0:0]
+	cg.p = cg.p[0:0]
+	cg.ap = cg.ap[0:0]
+}
+
+// Solve runs projected preconditioned conjugate gradient over the current
+// equation set and writes the resulting multiplier into each equation,
+// exactly like GaussSeidel.Solve.
+func (cg *PCG) Solve(frameDelta time.Duration, nBodies int) int {
+
+	cg.Reset()
+
+	nEquations := len(cg.equations)
+	h := float32(frameDelta.Seconds())
+
+	for i := 0; i < nBodies; i++ {
+		cg.VelocityDeltas = append(cg.VelocityDeltas, math32.Vector3{0, 0, 0})
+		cg.AngularVelocityDeltas = append(cg.AngularVelocityDeltas, math32.Vector3{0, 0, 0})
+		cg.scratchV = append(cg.scratchV, math32.Vector3{0, 0, 0})
+		cg.scratchW = append(cg.scratchW, math32.Vector3{0, 0, 0})
+	}
+
+	if nEquations == 0 {
+		return 0
+	}
+
+	// b = right-hand side, r0 = b (lambda starts at zero), z0 = M^-1 r0, p0 = z0.
+	for i := 0; i < nEquations; i++ {
+		eq := cg.equations[i]
+		invC := 1.0 / eq.ComputeC()
+		b := eq.ComputeB(h)
+
+		cg.solveInvCs = append(cg.solveInvCs, invC)
+		cg.lambda = append(cg.lambda, 0)
+		cg.r = append(cg.r, b)
+		cg.z = append(cg.z, invC*b)
+		cg.p = append(cg.p, cg.z[i])
+		cg.ap = append(cg.ap, 0)
+	}
+
+	tolSquared := cg.tolerance * cg.tolerance
+	rz := dotF32(cg.r, cg.z)
+
+	iter := 0
+	for ; iter < cg.maxIter; iter++ {
+
+		cg.matVec(cg.p, cg.ap)
+
+		pAp := dotF32(cg.p, cg.ap)
+		if pAp == 0 {
+			break
+		}
+		alpha := rz / pAp
+
+		for i := 0; i < nEquations; i++ {
+			eq := cg.equations[i]
+			next := cg.lambda[i] + alpha*cg.p[i]
+
+			// Project onto [MinForce, MaxForce]. A clamped component is no
+			// longer a valid descent direction, so zero it out of p; once z
+			// is recomputed below, p picks that direction back up fresh
+			// from the preconditioned residual instead of carrying stale
+			// momentum into it.
+			if next < eq.MinForce() {
+				next = eq.MinForce()
+				cg.p[i] = 0
+			} else if next > eq.MaxForce() {
+				next = eq.MaxForce()
+				cg.p[i] = 0
+			}
+			cg.lambda[i] = next
+			cg.r[i] -= alpha * cg.ap[i]
+		}
+
+		rNormSquared := dotF32(cg.r, cg.r)
+		if rNormSquared < tolSquared {
+			iter++
+			break
+		}
+
+		for i := 0; i < nEquations; i++ {
+			cg.z[i] = cg.solveInvCs[i] * cg.r[i]
+		}
+		rzNew := dotF32(cg.r, cg.z)
+		beta := rzNew / rz
+		for i := 0; i < nEquations; i++ {
+			cg.p[i] = cg.z[i] + beta*cg.p[i]
+		}
+		rz = rzNew
+	}
+
+	// Recompute the body velocity deltas implied by the converged lambda,
+	// the same quantities GaussSeidel accumulates incrementally as it goes.
+	cg.matVec(cg.lambda, cg.ap)
+	copy(cg.VelocityDeltas, cg.scratchV)
+	copy(cg.AngularVelocityDeltas, cg.scratchW)
+
+	for i := range cg.equations {
+		cg.equations[i].SetMultiplier(cg.lambda[i] / h)
+	}
+
+	return iter
+}
+
+// matVec computes out = A*in, where A is the (never materialized)
+// symmetric system A[i,j] = Ji*M^-1*Jj^T + eps*delta_ij. It sweeps the
+// equations twice: once to scatter each equation's contribution into
+// per-body velocity/angular velocity deltas, and once to gather, for each
+// equation, its two bodies' combined delta back into equation space.
+func (cg *PCG) matVec(in, out []float32) {
+
+	for i := range cg.scratchV {
+		cg.scratchV[i] = math32.Vector3{0, 0, 0}
+		cg.scratchW[i] = math32.Vector3{0, 0, 0}
+	}
+
+	nEquations := len(cg.equations)
+	for j := 0; j < nEquations; j++ {
+		eq := cg.equations[j]
+		idxBodyA := eq.BodyA().Index()
+		idxBodyB := eq.BodyB().Index()
+
+		jeA := eq.JeA()
+		jeB := eq.JeB()
+
+		cg.scratchV[idxBodyA].Add(jeA.Spatial().MultiplyScalar(eq.BodyA().InvMassSolve() * in[j]))
+		cg.scratchV[idxBodyB].Add(jeB.Spatial().MultiplyScalar(eq.BodyB().InvMassSolve() * in[j]))
+		cg.scratchW[idxBodyA].Add(jeA.Rotational().ApplyMatrix3(eq.BodyA().InvInertiaWorldSolve()).MultiplyScalar(in[j]))
+		cg.scratchW[idxBodyB].Add(jeB.Rotational().ApplyMatrix3(eq.BodyB().InvInertiaWorldSolve()).MultiplyScalar(in[j]))
+	}
+
+	for i := 0; i < nEquations; i++ {
+		eq := cg.equations[i]
+		idxBodyA := eq.BodyA().Index()
+		idxBodyB := eq.BodyB().Index()
+
+		jeA := eq.JeA()
+		jeB := eq.JeB()
+
+		GWp := jeA.MultiplyVectors(&cg.scratchV[idxBodyA], &cg.scratchW[idxBodyA]) +
+			jeB.MultiplyVectors(&cg.scratchV[idxBodyB], &cg.scratchW[idxBodyB])
+
+		out[i] = GWp + eq.Eps()*in[i]
+	}
+}
+
+// dotF32 returns the inner product of two equal-length float32 slices.
+func dotF32(a, b []float32) float32 {
+
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}